@@ -0,0 +1,277 @@
+// Package auth implements pluggable authentication for the requests
+// ClientViewGetter makes to a customer's data layer: static bearer
+// tokens, HTTP Basic, and OAuth2 client-credentials/OIDC token-endpoint
+// fetches with in-memory caching, plus docker-registry-style
+// WWW-Authenticate challenge parsing so a Source can be handed a 401 and
+// figure out where and how to get a fresh token.
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Kind selects how a Source authenticates.
+type Kind string
+
+const (
+	// KindBearer sends a fixed, pre-shared bearer token on every request.
+	KindBearer Kind = "bearer"
+	// KindBasic sends a fixed HTTP Basic username/password on every request.
+	KindBasic Kind = "basic"
+	// KindOIDC exchanges client credentials for a short-lived token at an
+	// OAuth2/OIDC token endpoint, as directed by the data layer's
+	// WWW-Authenticate challenge, and caches it until it's about to expire.
+	KindOIDC Kind = "oidc"
+)
+
+// Config configures how a ClientViewGetter authenticates to a single
+// account's data layer. It is carried on Account and threaded through
+// NewService.
+type Config struct {
+	Kind Kind `json:"kind"`
+
+	// Token is the bearer token to send, for KindBearer.
+	Token string `json:"token,omitempty"`
+
+	// Username and Password are the credentials to send, for KindBasic.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// ClientID, ClientSecret and Scopes are the client credentials sent to
+	// the token endpoint, for KindOIDC. TokenURL is the token endpoint to
+	// use when the data layer's challenge doesn't specify its own realm.
+	TokenURL     string   `json:"tokenUrl,omitempty"`
+	ClientID     string   `json:"clientId,omitempty"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+// cacheKey identifies a cached token by the realm/service/scope triple a
+// WWW-Authenticate challenge names, matching the docker registry token
+// protocol.
+type cacheKey struct {
+	realm, service, scope string
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+// Source produces Authorization header values for a single account,
+// fetching and caching OAuth2/OIDC tokens as needed. It is safe for
+// concurrent use.
+type Source struct {
+	cfg Config
+
+	mu    sync.Mutex
+	cache map[cacheKey]cachedToken
+
+	// lastKey is the cacheKey Authenticate most recently stored a token
+	// under, so Token can find that same cache entry on the next request
+	// without having to see the challenge again. haveLastKey is false
+	// until the first successful Authenticate.
+	lastKey     cacheKey
+	haveLastKey bool
+}
+
+// NewSource creates a Source for the given config.
+func NewSource(cfg Config) *Source {
+	return &Source{cfg: cfg, cache: map[cacheKey]cachedToken{}}
+}
+
+// Token returns the Authorization header value to send on the initial
+// request, before any WWW-Authenticate challenge has been seen. For
+// KindOIDC it returns "" (nothing to send until we're challenged), unless
+// a token is already cached from a previous Authenticate call on this
+// Source.
+func (s *Source) Token() (string, error) {
+	switch s.cfg.Kind {
+	case KindBearer:
+		return "Bearer " + s.cfg.Token, nil
+	case KindBasic:
+		enc := base64.StdEncoding.EncodeToString([]byte(s.cfg.Username + ":" + s.cfg.Password))
+		return "Basic " + enc, nil
+	case KindOIDC:
+		if tok, ok := s.cached(s.lastChallengeKey()); ok {
+			return "Bearer " + tok, nil
+		}
+		return "", nil
+	default:
+		return "", errors.Errorf("unknown auth kind %q", s.cfg.Kind)
+	}
+}
+
+// lastChallengeKey returns the cacheKey that the most recent successful
+// Authenticate call stored its token under, so Token can find it again on
+// a later request without having to see the challenge a second time. Before
+// any challenge has been resolved, it falls back to the bare TokenURL;
+// nothing is cached yet at that point, so a lookup under it finds nothing.
+func (s *Source) lastChallengeKey() cacheKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.haveLastKey {
+		return s.lastKey
+	}
+	return cacheKey{realm: s.cfg.TokenURL}
+}
+
+// Challenge is a parsed WWW-Authenticate header, e.g.
+//   Bearer realm="https://auth.example.com/token",service="data",scope="pull"
+type Challenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+// ParseChallenge parses a WWW-Authenticate header value into a Challenge.
+// It returns false if header isn't a Bearer challenge we know how to
+// satisfy.
+func ParseChallenge(header string) (Challenge, bool) {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return Challenge{}, false
+	}
+
+	params := map[string]string{}
+	for _, kv := range splitChallengeParams(parts[1]) {
+		eq := strings.IndexByte(kv, '=')
+		if eq < 0 {
+			continue
+		}
+		k := strings.TrimSpace(kv[:eq])
+		v := strings.Trim(strings.TrimSpace(kv[eq+1:]), `"`)
+		params[k] = v
+	}
+	if params["realm"] == "" {
+		return Challenge{}, false
+	}
+	return Challenge{Scheme: "Bearer", Params: params}, true
+}
+
+// splitChallengeParams splits the comma-separated realm="...",service="...'
+// portion of a WWW-Authenticate header, respecting commas inside quotes.
+func splitChallengeParams(s string) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i, r := range s {
+		switch r {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, s[start:])
+	return parts
+}
+
+// Authenticate resolves a WWW-Authenticate challenge into an Authorization
+// header value, fetching a fresh token from the challenge's token endpoint
+// (or the configured TokenURL, if the challenge didn't name one) using the
+// account's client credentials, and caching it until shortly before it
+// expires.
+func (s *Source) Authenticate(c Challenge) (string, error) {
+	if s.cfg.Kind != KindOIDC {
+		return "", errors.New("account has no OAuth2/OIDC credentials configured")
+	}
+
+	realm := c.Params["realm"]
+	if realm == "" {
+		realm = s.cfg.TokenURL
+	}
+	key := cacheKey{realm: realm, service: c.Params["service"], scope: c.Params["scope"]}
+
+	if tok, ok := s.cached(key); ok {
+		return "Bearer " + tok, nil
+	}
+
+	tok, expires, err := s.fetchToken(realm, c.Params["service"], c.Params["scope"])
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.cache[key] = cachedToken{token: tok, expires: expires}
+	s.lastKey = key
+	s.haveLastKey = true
+	s.mu.Unlock()
+
+	return "Bearer " + tok, nil
+}
+
+func (s *Source) cached(key cacheKey) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.cache[key]
+	// Refresh a little before expiry so a near-expired token never gets
+	// used for a request that might outlive it.
+	if !ok || time.Now().Add(30*time.Second).After(t.expires) {
+		return "", false
+	}
+	return t.token, true
+}
+
+// tokenResponse is the subset of an OAuth2 client-credentials token
+// response we care about. See RFC 6749 section 4.4.3.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (s *Source) fetchToken(tokenURL, service, scope string) (string, time.Time, error) {
+	if tokenURL == "" {
+		return "", time.Time{}, errors.New("no token URL configured or challenged for")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", s.cfg.ClientID)
+	form.Set("client_secret", s.cfg.ClientSecret)
+	if service != "" {
+		form.Set("service", service)
+	}
+	var scopes []string
+	if scope != "" {
+		scopes = append(append(scopes, s.cfg.Scopes...), scope)
+	} else {
+		scopes = s.cfg.Scopes
+	}
+	if len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+
+	resp, err := http.PostForm(tokenURL, form)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "Could not reach token endpoint")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, errors.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", time.Time{}, errors.Wrap(err, "Could not parse token endpoint response")
+	}
+	if tr.AccessToken == "" {
+		return "", time.Time{}, errors.New("token endpoint response is missing access_token")
+	}
+
+	expiresIn := tr.ExpiresIn
+	if expiresIn == 0 {
+		expiresIn = 60
+	}
+	return tr.AccessToken, time.Now().Add(time.Duration(expiresIn) * time.Second), nil
+}
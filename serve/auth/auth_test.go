@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSource_Token(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		want    string
+		wantErr string
+	}{
+		{"bearer", Config{Kind: KindBearer, Token: "tok"}, "Bearer tok", ""},
+		{"basic", Config{Kind: KindBasic, Username: "u", Password: "p"}, "Basic dTpw", ""},
+		{"oidc with nothing cached yet", Config{Kind: KindOIDC, TokenURL: "http://example.com/token"}, "", ""},
+		{"unknown kind", Config{Kind: "bogus"}, "", "unknown auth kind"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSource(tt.cfg)
+			got, err := s.Token()
+			if tt.wantErr == "" {
+				assert.NoError(err)
+			} else {
+				assert.Error(err)
+				assert.Regexp(tt.wantErr, err.Error())
+			}
+			assert.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		name   string
+		header string
+		want   Challenge
+		wantOK bool
+	}{
+		{
+			"realm, service and scope",
+			`Bearer realm="https://auth.example.com/token",service="data",scope="pull"`,
+			Challenge{Scheme: "Bearer", Params: map[string]string{
+				"realm": "https://auth.example.com/token", "service": "data", "scope": "pull",
+			}},
+			true,
+		},
+		{"not bearer", `Basic realm="x"`, Challenge{}, false},
+		{"missing realm", `Bearer service="data"`, Challenge{}, false},
+		{"garbage", `total nonsense`, Challenge{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseChallenge(tt.header)
+			assert.Equal(tt.wantOK, ok)
+			if tt.wantOK {
+				assert.Equal(tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSource_Authenticate(t *testing.T) {
+	assert := assert.New(t)
+
+	var tokenRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		assert.NoError(r.ParseForm())
+		assert.Equal("client_credentials", r.Form.Get("grant_type"))
+		assert.Equal("id", r.Form.Get("client_id"))
+		assert.Equal("data", r.Form.Get("service"))
+		assert.Equal("pull", r.Form.Get("scope"))
+		w.Write([]byte(`{"access_token": "tok1", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	s := NewSource(Config{Kind: KindOIDC, ClientID: "id", ClientSecret: "secret"})
+	c := Challenge{Scheme: "Bearer", Params: map[string]string{"realm": server.URL, "service": "data", "scope": "pull"}}
+
+	got, err := s.Authenticate(c)
+	assert.NoError(err)
+	assert.Equal("Bearer tok1", got)
+	assert.Equal(1, tokenRequests)
+
+	// A second call for the same realm/service/scope is served from cache,
+	// not a fresh token request.
+	got, err = s.Authenticate(c)
+	assert.NoError(err)
+	assert.Equal("Bearer tok1", got)
+	assert.Equal(1, tokenRequests)
+}
+
+func TestSource_Authenticate_NotOIDC(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSource(Config{Kind: KindBearer, Token: "tok"})
+	_, err := s.Authenticate(Challenge{Scheme: "Bearer", Params: map[string]string{"realm": "http://example.com"}})
+	assert.Error(err)
+	assert.Regexp("no OAuth2/OIDC credentials", err.Error())
+}
+
+func TestSource_Authenticate_TokenEndpointErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		name     string
+		respCode int
+		respBody string
+		wantErr  string
+	}{
+		{"non-200", http.StatusUnauthorized, ``, "401"},
+		{"missing access_token", http.StatusOK, `{"expires_in": 60}`, "access_token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.respCode)
+				w.Write([]byte(tt.respBody))
+			}))
+			defer server.Close()
+
+			s := NewSource(Config{Kind: KindOIDC, ClientID: "id", ClientSecret: "secret"})
+			_, err := s.Authenticate(Challenge{Scheme: "Bearer", Params: map[string]string{"realm": server.URL}})
+			assert.Error(err)
+			assert.Regexp(tt.wantErr, err.Error())
+		})
+	}
+}
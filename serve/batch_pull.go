@@ -0,0 +1,176 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	servetypes "roci.dev/diff-server/serve/types"
+	"roci.dev/diff-server/util/loghttp"
+)
+
+// maxBatchPullWorkers bounds how many pulls within a single batch run
+// concurrently, so one big batch can't monopolize goroutines or hammer a
+// data layer all at once.
+const maxBatchPullWorkers = 8
+
+// batchPull handles /batchPull: many clients' pulls, all on one account,
+// in a single round trip. This is a big win for mobile apps that resume
+// and pull for several local profiles at once, and for server-driven
+// fan-out. Its request/response shape follows the LFS batch API -- a top
+// -level operation plus objects[], each with its own status -- so one
+// client's failure doesn't fail the whole batch.
+func (s *Service) batchPull(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		unsupportedMethodError(w, r, r.Method)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clientError(w, r, http.StatusBadRequest, errors.Wrap(err, "Could not read request body").Error())
+		return
+	}
+
+	var req servetypes.BatchPullRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		clientError(w, r, http.StatusBadRequest, errors.Wrap(err, "Bad request payload").Error())
+		return
+	}
+	if req.Operation != "pull" {
+		clientError(w, r, http.StatusBadRequest, fmt.Sprintf("Unsupported operation: %s", req.Operation))
+		return
+	}
+	if req.AccountID == "" {
+		clientError(w, r, http.StatusBadRequest, "Missing accountID")
+		return
+	}
+	account, ok := s.lookupAccount(req.AccountID)
+	if !ok {
+		clientError(w, r, http.StatusBadRequest, "Unknown accountID")
+		return
+	}
+	if err := s.verifySignature(account, body, r.Header); err != nil {
+		clientError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := s.handleBatchPull(r.Context(), req, r.Header.Get("Authorization"))
+
+	w.Header().Set("Content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		serverError(w, r, err)
+	}
+}
+
+// handleBatchPull runs one pull per object in req.Pulls, bounded to
+// maxBatchPullWorkers at a time, coalescing client view fetches that
+// would otherwise be identical -- same account, data layer endpoint, auth
+// token, and clientID -- into a single upstream request shared by every
+// object that needs it.
+func (s *Service) handleBatchPull(ctx context.Context, req servetypes.BatchPullRequest, authHeader string) servetypes.BatchPullResponse {
+	fetch := s.coalescedClientViewFetcher(req.AccountID, authHeader)
+
+	results := make([]servetypes.BatchPullResult, len(req.Pulls))
+	sem := make(chan struct{}, maxBatchPullWorkers)
+	var wg sync.WaitGroup
+	for i, obj := range req.Pulls {
+		i, obj := i, obj
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = s.batchPullOne(ctx, req.AccountID, obj, fetch)
+		}()
+	}
+	wg.Wait()
+
+	return servetypes.BatchPullResponse{Objects: results}
+}
+
+// batchPullOne runs a single object's pull, using fetch to get its
+// client view (if any fetcher is configured for the account).
+func (s *Service) batchPullOne(ctx context.Context, accountID string, obj servetypes.BatchPullObject, fetch clientViewFetcher) servetypes.BatchPullResult {
+	if obj.ClientID == "" {
+		return servetypes.BatchPullResult{Status: http.StatusBadRequest, Error: "Missing clientID"}
+	}
+
+	var cvr *servetypes.ClientViewResponse
+	if fetch != nil {
+		resp, err := fetch(ctx, obj.ClientID)
+		if err != nil {
+			loghttp.Logger(ctx).Error().Err(err).Str("clientID", obj.ClientID).
+				Msg("Could not fetch client view; serving previously synced data")
+		} else {
+			cvr = &resp
+		}
+	}
+
+	resp, err := s.pullOne(ctx, accountID, obj.ClientID, obj.BaseStateID, obj.Checksum, cvr)
+	if err != nil {
+		return servetypes.BatchPullResult{ClientID: obj.ClientID, Status: http.StatusBadRequest, Error: err.Error()}
+	}
+	return servetypes.BatchPullResult{ClientID: obj.ClientID, Status: http.StatusOK, Pull: &resp}
+}
+
+// clientViewFetcher fetches the client view for a single client, sharing
+// the underlying upstream request with any other client in the same
+// batch that would otherwise trigger an identical fetch.
+type clientViewFetcher func(ctx context.Context, clientID string) (servetypes.ClientViewResponse, error)
+
+// coalescedClientViewFetcher returns a clientViewFetcher for accountID
+// that performs each distinct (accountID, client view endpoint, auth
+// token, clientID) fetch at most once, so if the same clientID appears
+// more than once in a batch it's only fetched once, and fans that single
+// result out to every occurrence. Objects in a batch almost always have
+// distinct clientIDs -- that's the point of batching several local
+// profiles together -- so this coalesces little in practice; it must not
+// share a fetch across different clientIDs, since each one's
+// ClientViewResponse is specific to that client and handing client A's
+// response to client B would silently corrupt client B's data. It returns
+// nil if the account has no client view fetcher configured, matching
+// pull's behavior of skipping the fetch entirely in that case.
+func (s *Service) coalescedClientViewFetcher(accountID, authHeader string) clientViewFetcher {
+	cvg := s.clientViewGetter(accountID)
+	if cvg == nil {
+		return nil
+	}
+
+	type result struct {
+		resp servetypes.ClientViewResponse
+		err  error
+	}
+	var mu sync.Mutex
+	once := map[string]*sync.Once{}
+	results := map[string]*result{}
+
+	return func(ctx context.Context, clientID string) (servetypes.ClientViewResponse, error) {
+		key := fmt.Sprintf("%s|%s|%s|%s", accountID, s.clientViewURL, authHeader, clientID)
+
+		mu.Lock()
+		o, ok := once[key]
+		if !ok {
+			o = &sync.Once{}
+			once[key] = o
+		}
+		mu.Unlock()
+
+		o.Do(func() {
+			resp, err := cvg.Get(ctx, servetypes.ClientViewRequest{ClientID: clientID}, authHeader)
+			mu.Lock()
+			results[key] = &result{resp: resp, err: err}
+			mu.Unlock()
+		})
+
+		mu.Lock()
+		r := results[key]
+		mu.Unlock()
+		return r.resp, r.err
+	}
+}
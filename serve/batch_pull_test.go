@@ -0,0 +1,143 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/attic-labs/noms/go/types"
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/db"
+	"roci.dev/diff-server/kv"
+	servetypes "roci.dev/diff-server/serve/types"
+)
+
+// keyedFakeClientViewGet returns a distinct, pre-configured response per
+// clientID, so tests can tell whether a batch ever hands one client's
+// client view to another.
+type keyedFakeClientViewGet struct {
+	responses map[string]servetypes.ClientViewResponse
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *keyedFakeClientViewGet) Get(ctx context.Context, req servetypes.ClientViewRequest, authToken string) (servetypes.ClientViewResponse, error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = map[string]int{}
+	}
+	f.calls[req.ClientID]++
+	f.mu.Unlock()
+	return f.responses[req.ClientID], nil
+}
+
+func TestBatchPull_DistinctClientsGetTheirOwnClientView(t *testing.T) {
+	assert := assert.New(t)
+
+	td, _ := ioutil.TempDir("", "")
+	s := NewService(td, []Account{{ID: "accountID", Name: "accountID"}}, "")
+
+	clientIDs := []string{"client1", "client2", "client3"}
+	fcvg := &keyedFakeClientViewGet{responses: map[string]servetypes.ClientViewResponse{}}
+	for i, clientID := range clientIDs {
+		noms, err := s.getNoms(context.Background(), "accountID")
+		assert.NoError(err)
+		d, err := db.New(noms.GetDataset("client/" + clientID))
+		assert.NoError(err)
+		m := kv.NewMapFromNoms(noms, types.NewMap(noms, types.String("foo"), types.String("bar")))
+		assert.NoError(d.PutData(m.NomsMap(), types.String(m.Checksum().String()), 1))
+
+		fcvg.responses[clientID] = servetypes.ClientViewResponse{
+			ClientView:     map[string]interface{}{"owner": clientID},
+			LastMutationID: uint64(i + 1),
+		}
+	}
+	s.cvg = fcvg
+
+	pulls := make([]servetypes.BatchPullObject, len(clientIDs))
+	for i, clientID := range clientIDs {
+		pulls[i] = servetypes.BatchPullObject{
+			ClientID:    clientID,
+			BaseStateID: "00000000000000000000000000000000",
+			Checksum:    "00000000",
+		}
+	}
+	reqBody, err := json.Marshal(servetypes.BatchPullRequest{
+		Operation: "pull",
+		AccountID: "accountID",
+		Pulls:     pulls,
+	})
+	assert.NoError(err)
+
+	req := httptest.NewRequest("POST", "/batchPull", bytes.NewReader(reqBody))
+	resp := httptest.NewRecorder()
+	s.batchPull(resp, req)
+
+	var body bytes.Buffer
+	_, err = io.Copy(&body, resp.Result().Body)
+	assert.NoError(err)
+
+	var batchResp servetypes.BatchPullResponse
+	assert.NoError(json.Unmarshal(body.Bytes(), &batchResp))
+	assert.Len(batchResp.Objects, len(clientIDs))
+
+	byClientID := map[string]servetypes.BatchPullResult{}
+	for _, r := range batchResp.Objects {
+		byClientID[r.ClientID] = r
+	}
+	for i, clientID := range clientIDs {
+		r, ok := byClientID[clientID]
+		assert.True(ok, clientID)
+		assert.Equal(200, r.Status, clientID)
+		assert.NotNil(r.Pull, clientID)
+		assert.Equal(uint64(i+1), r.Pull.LastMutationID, "client %s got another client's response", clientID)
+	}
+}
+
+func TestBatchPull_PartialFailureDoesNotFailWholeBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	td, _ := ioutil.TempDir("", "")
+	s := NewService(td, []Account{{ID: "accountID", Name: "accountID"}}, "")
+	s.cvg = nil
+
+	noms, err := s.getNoms(context.Background(), "accountID")
+	assert.NoError(err)
+	d, err := db.New(noms.GetDataset("client/ok"))
+	assert.NoError(err)
+	m := kv.NewMapFromNoms(noms, types.NewMap(noms, types.String("foo"), types.String("bar")))
+	assert.NoError(d.PutData(m.NomsMap(), types.String(m.Checksum().String()), 1))
+
+	reqBody, err := json.Marshal(servetypes.BatchPullRequest{
+		Operation: "pull",
+		AccountID: "accountID",
+		Pulls: []servetypes.BatchPullObject{
+			{ClientID: "ok", BaseStateID: "00000000000000000000000000000000", Checksum: "00000000"},
+			{ClientID: "", BaseStateID: "00000000000000000000000000000000", Checksum: "00000000"},
+		},
+	})
+	assert.NoError(err)
+
+	req := httptest.NewRequest("POST", "/batchPull", bytes.NewReader(reqBody))
+	resp := httptest.NewRecorder()
+	s.batchPull(resp, req)
+	assert.Equal(200, resp.Result().StatusCode)
+
+	var body bytes.Buffer
+	_, err = io.Copy(&body, resp.Result().Body)
+	assert.NoError(err)
+
+	var batchResp servetypes.BatchPullResponse
+	assert.NoError(json.Unmarshal(body.Bytes(), &batchResp))
+	assert.Len(batchResp.Objects, 2)
+	assert.Equal(200, batchResp.Objects[0].Status)
+	assert.Equal(400, batchResp.Objects[1].Status)
+	assert.Regexp("Missing clientID", batchResp.Objects[1].Error)
+}
@@ -0,0 +1,68 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"roci.dev/diff-server/serve/clientviewpb"
+	servetypes "roci.dev/diff-server/serve/types"
+)
+
+// grpcClientViewGetter fetches a client view over gRPC, for accounts
+// whose ClientViewEndpoint.Kind is ClientViewEndpointGRPC. It dials
+// target lazily on first use and reuses the connection afterward.
+type grpcClientViewGetter struct {
+	target string
+
+	mu   sync.Mutex
+	conn *grpc.ClientConn
+}
+
+func newGRPCClientViewGetter(target string) *grpcClientViewGetter {
+	return &grpcClientViewGetter{target: target}
+}
+
+func (g *grpcClientViewGetter) client() (clientviewpb.ClientViewServiceClient, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conn == nil {
+		conn, err := grpc.Dial(g.target,
+			grpc.WithInsecure(),
+			grpc.WithDefaultCallOptions(grpc.CallContentSubtype("json")))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not dial client view gRPC target %s", g.target)
+		}
+		g.conn = conn
+	}
+	return clientviewpb.NewClientViewServiceClient(g.conn), nil
+}
+
+// Get fetches the client view for req over gRPC. authToken, if set, is
+// forwarded as gRPC metadata so accounts that authenticate their data
+// layer at the transport level still work.
+func (g *grpcClientViewGetter) Get(ctx context.Context, req servetypes.ClientViewRequest, authToken string) (servetypes.ClientViewResponse, error) {
+	client, err := g.client()
+	if err != nil {
+		return servetypes.ClientViewResponse{}, err
+	}
+
+	if authToken != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", authToken)
+	}
+
+	resp, err := client.Get(ctx, &clientviewpb.ClientViewRequest{ClientID: req.ClientID})
+	if err != nil {
+		return servetypes.ClientViewResponse{}, errors.Wrap(err, "Could not fetch client view over gRPC")
+	}
+
+	var cv map[string]interface{}
+	if err := json.Unmarshal(resp.ClientViewJSON, &cv); err != nil {
+		return servetypes.ClientViewResponse{}, errors.Wrap(err, "Could not parse client view")
+	}
+	return servetypes.ClientViewResponse{ClientView: cv, LastMutationID: resp.LastMutationID}, nil
+}
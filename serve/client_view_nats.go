@@ -0,0 +1,97 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	servetypes "roci.dev/diff-server/serve/types"
+)
+
+// defaultNATSTimeout bounds how long we wait for a data layer's reply
+// when the account's ClientViewEndpoint.Options doesn't set "timeout".
+const defaultNATSTimeout = 5 * time.Second
+
+// natsClientViewGetter fetches a client view over NATS request-reply:
+// diff-server publishes a ClientViewRequest on subject
+// "clientview.<accountID>" and awaits a reply. This lets operators
+// integrate diff-server with an internal service mesh without exposing
+// an HTTP endpoint just for us.
+type natsClientViewGetter struct {
+	serverURL string
+	subject   string
+	timeout   time.Duration
+
+	mu sync.Mutex
+	nc *nats.Conn
+}
+
+func newNATSClientViewGetter(serverURL, accountID string, options map[string]string) *natsClientViewGetter {
+	timeout := defaultNATSTimeout
+	if v, ok := options["timeout"]; ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			timeout = d
+		}
+	}
+	return &natsClientViewGetter{
+		serverURL: serverURL,
+		subject:   fmt.Sprintf("clientview.%s", accountID),
+		timeout:   timeout,
+	}
+}
+
+func (g *natsClientViewGetter) conn() (*nats.Conn, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.nc == nil {
+		nc, err := nats.Connect(g.serverURL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not connect to NATS server %s", g.serverURL)
+		}
+		g.nc = nc
+	}
+	return g.nc, nil
+}
+
+// natsClientViewRequest is what we publish on g.subject; it's the normal
+// ClientViewRequest plus the auth token the Replicache client sent us,
+// since NATS has no header concept of its own to carry it in.
+type natsClientViewRequest struct {
+	servetypes.ClientViewRequest
+	AuthToken string `json:"authToken,omitempty"`
+}
+
+// Get fetches the client view for req over NATS request-reply.
+func (g *natsClientViewGetter) Get(ctx context.Context, req servetypes.ClientViewRequest, authToken string) (servetypes.ClientViewResponse, error) {
+	nc, err := g.conn()
+	if err != nil {
+		return servetypes.ClientViewResponse{}, err
+	}
+
+	body, err := json.Marshal(natsClientViewRequest{ClientViewRequest: req, AuthToken: authToken})
+	if err != nil {
+		return servetypes.ClientViewResponse{}, errors.Wrap(err, "Could not marshal client view request")
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		defer cancel()
+	}
+
+	msg, err := nc.RequestWithContext(ctx, g.subject, body)
+	if err != nil {
+		return servetypes.ClientViewResponse{}, errors.Wrapf(err, "Could not fetch client view over NATS (subject %s)", g.subject)
+	}
+
+	var cvr servetypes.ClientViewResponse
+	if err := json.Unmarshal(msg.Data, &cvr); err != nil {
+		return servetypes.ClientViewResponse{}, errors.Wrap(err, "Could not parse client view response")
+	}
+	return cvr, nil
+}
@@ -1,6 +1,7 @@
 package serve
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -69,7 +70,7 @@ func TestClientViewGetter_Get(t *testing.T) {
 			g := ClientViewGetter{
 				url: server.URL,
 			}
-			got, err := g.Get(tt.req, tt.authToken)
+			got, err := g.Get(context.Background(), tt.req, tt.authToken)
 			if tt.wantErr == "" {
 				assert.NoError(err)
 			} else {
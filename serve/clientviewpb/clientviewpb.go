@@ -0,0 +1,75 @@
+// Package clientviewpb holds the client and wire types for
+// ClientViewService.
+//
+// clientview.proto, alongside this file, documents the service and
+// message shapes for reference, but nothing here is generated from it
+// with protoc, and messages are NOT sent as real protobuf on the wire.
+// Get sends ClientViewRequest/ClientViewResponse as JSON, over gRPC's
+// framing, using the "json" codec registered below in place of the
+// standard protobuf codec. This is an implementation shortcut, not a
+// protobuf-compatible service: a customer's own data layer must
+// register the same non-standard codec (see jsonCodec) to talk to it --
+// a stock protoc-generated gRPC server in any language will not
+// understand these requests. If true cross-language protobuf
+// interoperability is ever needed, this package should be regenerated
+// from clientview.proto with protoc instead.
+package clientviewpb
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// ClientViewRequest mirrors roci.dev/diff-server/serve/types.ClientViewRequest.
+type ClientViewRequest struct {
+	ClientID string `json:"clientId"`
+}
+
+// ClientViewResponse mirrors roci.dev/diff-server/serve/types.ClientViewResponse,
+// with the client view itself carried as opaque JSON since its shape is
+// up to the customer's data layer.
+type ClientViewResponse struct {
+	ClientViewJSON []byte `json:"clientViewJson"`
+	LastMutationID uint64 `json:"lastMutationId"`
+}
+
+// ClientViewServiceClient is the gRPC client for ClientViewService.
+type ClientViewServiceClient interface {
+	Get(ctx context.Context, in *ClientViewRequest, opts ...grpc.CallOption) (*ClientViewResponse, error)
+}
+
+type clientViewServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewClientViewServiceClient creates a ClientViewServiceClient backed by cc.
+func NewClientViewServiceClient(cc *grpc.ClientConn) ClientViewServiceClient {
+	return &clientViewServiceClient{cc: cc}
+}
+
+func (c *clientViewServiceClient) Get(ctx context.Context, in *ClientViewRequest, opts ...grpc.CallOption) (*ClientViewResponse, error) {
+	out := new(ClientViewResponse)
+	err := c.cc.Invoke(ctx, "/clientviewpb.ClientViewService/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// jsonCodecName is registered as a grpc encoding.Codec so ClientViewRequest
+// and ClientViewResponse can be sent as JSON instead of requiring a full
+// protobuf toolchain.
+const jsonCodecName = "json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string { return jsonCodecName }
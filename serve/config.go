@@ -0,0 +1,320 @@
+package serve
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/attic-labs/noms/go/datas"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+
+	"roci.dev/diff-server/util/loghttp"
+)
+
+// Config is the hot-reloadable configuration for a Service: the set of
+// known accounts and how to reach their data layers.
+type Config struct {
+	Accounts []Account `json:"accounts"`
+}
+
+// Fingerprint returns a hash of cfg's canonical JSON encoding, used to
+// detect concurrent edits in DoLockedAction.
+func (c Config) Fingerprint() (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", errors.Wrap(err, "Could not marshal config")
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NewServiceFromConfigFile creates a Service whose accounts are loaded
+// from the JSON file at configPath and kept in sync with it: a fsnotify
+// watcher reloads the in-memory snapshot whenever the file changes on
+// disk, and DoLockedAction lets admin tools make compare-and-swap edits
+// to it without racing other writers or the watcher.
+func NewServiceFromConfigFile(storageRoot, configPath, clientViewURL string) (*Service, error) {
+	s := &Service{
+		storageRoot:   storageRoot,
+		clientViewURL: clientViewURL,
+		configPath:    configPath,
+		dbs:           map[string]datas.Database{},
+		cvgCache:      map[string]clientViewGet{},
+	}
+	if err := s.reloadConfig(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create config file watcher")
+	}
+	if err := watcher.Add(filepath.Dir(configPath)); err != nil {
+		watcher.Close()
+		return nil, errors.Wrapf(err, "Could not watch directory of %s", configPath)
+	}
+	s.watcher = watcher
+	s.watcherDone = make(chan struct{})
+	go s.watchConfig()
+
+	return s, nil
+}
+
+// Close stops the config file watcher and waits for watchConfig's goroutine
+// to exit. It's a no-op for Services that aren't watching a config file
+// (ie created with NewService). Callers should Close every Service created
+// with NewServiceFromConfigFile once they're done with it, or its fsnotify
+// watch and goroutine leak for the life of the process.
+func (s *Service) Close() error {
+	if s.watcher == nil {
+		return nil
+	}
+	err := s.watcher.Close()
+	<-s.watcherDone
+	return err
+}
+
+// watchConfig reloads the config snapshot whenever configPath is written.
+// It runs for the lifetime of the Service, until watcher is closed.
+func (s *Service) watchConfig() {
+	defer close(s.watcherDone)
+	for {
+		select {
+		case ev, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != filepath.Clean(s.configPath) {
+				continue
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if err := s.reloadConfig(); err != nil {
+				loghttp.Logger(context.Background()).Error().Err(err).Msg("Could not reload config after file change")
+			}
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			loghttp.Logger(context.Background()).Error().Err(err).Msg("Config file watcher error")
+		}
+	}
+}
+
+// reloadConfig reads configPath from disk and installs it as the current
+// snapshot, invalidating any cached per-account client view getters so
+// they're rebuilt against the new settings. It takes cfgMu, the same lock
+// DoLockedAction holds while it writes, so a reload from disk can't
+// interleave with (and silently revert) a concurrent admin edit.
+func (s *Service) reloadConfig() error {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	b, err := ioutil.ReadFile(s.configPath)
+	if err != nil {
+		return errors.Wrapf(err, "Could not read config file %s", s.configPath)
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return errors.Wrapf(err, "Could not parse config file %s", s.configPath)
+	}
+	s.applyConfig(cfg)
+	return nil
+}
+
+// applyConfig installs cfg as the current snapshot and drops any cached
+// client view getters, since the accounts they were built from may have
+// changed.
+func (s *Service) applyConfig(cfg Config) {
+	s.config.Store(cfg)
+	s.mu.Lock()
+	s.cvgCache = map[string]clientViewGet{}
+	s.mu.Unlock()
+}
+
+// Fingerprint returns a hash of the current config snapshot, for use with
+// DoLockedAction.
+func (s *Service) Fingerprint() (string, error) {
+	return s.config.Load().(Config).Fingerprint()
+}
+
+// DoLockedAction applies fn to a mutable copy of the current config and
+// persists the result, but only if fingerprint still matches the config
+// on disk when the write happens. This gives admin tools compare-and-
+// swap semantics: read the config and its Fingerprint, let a human edit
+// it, then call DoLockedAction with the fingerprint they started from so
+// a concurrent edit from someone else is detected instead of silently
+// overwritten.
+func (s *Service) DoLockedAction(fingerprint string, fn func(cfg *Config) error) error {
+	if s.configPath == "" {
+		return errors.New("Service has no config file to update")
+	}
+
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+
+	b, err := ioutil.ReadFile(s.configPath)
+	if err != nil {
+		return errors.Wrapf(err, "Could not read config file %s", s.configPath)
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return errors.Wrapf(err, "Could not parse config file %s", s.configPath)
+	}
+
+	current, err := cfg.Fingerprint()
+	if err != nil {
+		return err
+	}
+	if current != fingerprint {
+		return errors.Errorf("Config has changed since fingerprint %s was read (now %s)", fingerprint, current)
+	}
+
+	if err := fn(&cfg); err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Could not marshal config")
+	}
+	tmp := s.configPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, out, 0644); err != nil {
+		return errors.Wrapf(err, "Could not write %s", tmp)
+	}
+	if err := os.Rename(tmp, s.configPath); err != nil {
+		return errors.Wrapf(err, "Could not replace %s", s.configPath)
+	}
+
+	s.applyConfig(cfg)
+	return nil
+}
+
+// MarshalJSONPath returns the JSON-encoded value at ptr, an RFC 6901 JSON
+// Pointer (eg "/accounts/0/pubkey"), within cfg. This lets an operator
+// read a single field of a large config without fetching the whole file.
+func (c Config) MarshalJSONPath(ptr string) ([]byte, error) {
+	root, err := toJSONTree(c)
+	if err != nil {
+		return nil, err
+	}
+	v, err := jsonPointerGet(root, ptr)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSONPath decodes data into the value at ptr within cfg,
+// replacing it in place. This lets an operator PATCH a single field (eg
+// one account's pubkey) without resubmitting the whole config.
+func (c *Config) UnmarshalJSONPath(ptr string, data []byte) error {
+	root, err := toJSONTree(*c)
+	if err != nil {
+		return err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.Wrap(err, "Could not parse value")
+	}
+	if err := jsonPointerSet(root, ptr, value); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(root)
+	if err != nil {
+		return errors.Wrap(err, "Could not marshal config")
+	}
+	var updated Config
+	if err := json.Unmarshal(b, &updated); err != nil {
+		return errors.Wrap(err, "Could not parse updated config")
+	}
+	*c = updated
+	return nil
+}
+
+func toJSONTree(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not marshal config")
+	}
+	var tree interface{}
+	if err := json.Unmarshal(b, &tree); err != nil {
+		return nil, errors.Wrap(err, "Could not parse config")
+	}
+	return tree, nil
+}
+
+// jsonPointerTokens splits an RFC 6901 JSON Pointer into its unescaped
+// reference tokens. "" and "/" both mean the whole document.
+func jsonPointerTokens(ptr string) []string {
+	if ptr == "" || ptr == "/" {
+		return nil
+	}
+	parts := strings.Split(strings.TrimPrefix(ptr, "/"), "/")
+	for i, p := range parts {
+		p = strings.ReplaceAll(p, "~1", "/")
+		p = strings.ReplaceAll(p, "~0", "~")
+		parts[i] = p
+	}
+	return parts
+}
+
+func jsonPointerGet(root interface{}, ptr string) (interface{}, error) {
+	cur := root
+	for _, tok := range jsonPointerTokens(ptr) {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			v, ok := node[tok]
+			if !ok {
+				return nil, errors.Errorf("No such path: %s", ptr)
+			}
+			cur = v
+		case []interface{}:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, errors.Errorf("No such path: %s", ptr)
+			}
+			cur = node[i]
+		default:
+			return nil, errors.Errorf("No such path: %s", ptr)
+		}
+	}
+	return cur, nil
+}
+
+func jsonPointerSet(root interface{}, ptr string, value interface{}) error {
+	tokens := jsonPointerTokens(ptr)
+	if len(tokens) == 0 {
+		return errors.New("Cannot replace the document root; pass a non-empty path")
+	}
+
+	parent, err := jsonPointerGet(root, "/"+strings.Join(tokens[:len(tokens)-1], "/"))
+	if err != nil {
+		return err
+	}
+	last := tokens[len(tokens)-1]
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		i, err := strconv.Atoi(last)
+		if err != nil || i < 0 || i >= len(node) {
+			return errors.Errorf("No such path: %s", ptr)
+		}
+		node[i] = value
+	default:
+		return errors.Errorf("No such path: %s", ptr)
+	}
+	return nil
+}
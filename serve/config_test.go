@@ -0,0 +1,152 @@
+package serve
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Fingerprint(t *testing.T) {
+	assert := assert.New(t)
+
+	a := Config{Accounts: []Account{{ID: "a", Name: "a"}}}
+	b := Config{Accounts: []Account{{ID: "a", Name: "a"}}}
+	c := Config{Accounts: []Account{{ID: "a", Name: "b"}}}
+
+	fpA, err := a.Fingerprint()
+	assert.NoError(err)
+	fpB, err := b.Fingerprint()
+	assert.NoError(err)
+	fpC, err := c.Fingerprint()
+	assert.NoError(err)
+
+	assert.Equal(fpA, fpB, "identical configs must have identical fingerprints")
+	assert.NotEqual(fpA, fpC, "different configs must have different fingerprints")
+}
+
+func TestConfig_JSONPath_RoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := Config{Accounts: []Account{
+		{ID: "a1", Name: "one", Pubkey: []byte{1, 2, 3}},
+		{ID: "a2", Name: "two"},
+	}}
+
+	// Reads use the same lowerCamelCase field names the config file (and
+	// an operator's PATCH request) would use.
+	got, err := cfg.MarshalJSONPath("/accounts/0/pubkey")
+	assert.NoError(err)
+	var pubkey []byte
+	assert.NoError(json.Unmarshal(got, &pubkey))
+	assert.Equal([]byte{1, 2, 3}, pubkey)
+
+	got, err = cfg.MarshalJSONPath("/accounts/1/name")
+	assert.NoError(err)
+	assert.Equal(`"two"`, string(got))
+
+	// A write to one path doesn't disturb the rest of the config.
+	assert.NoError(cfg.UnmarshalJSONPath("/accounts/1/pubkey", []byte(`"BAUG"`)))
+	assert.Equal("a2", cfg.Accounts[1].ID)
+	assert.Equal([]byte{4, 5, 6}, cfg.Accounts[1].Pubkey)
+	assert.Equal("one", cfg.Accounts[0].Name)
+
+	_, err = cfg.MarshalJSONPath("/accounts/5/name")
+	assert.Error(err)
+}
+
+func TestDoLockedAction_RejectsStaleFingerprint(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "")
+	configPath := filepath.Join(dir, "accounts.json")
+	writeConfig(t, configPath, Config{Accounts: []Account{{ID: "a", Name: "a"}}})
+
+	s, err := NewServiceFromConfigFile(dir, configPath, "")
+	assert.NoError(err)
+	defer s.Close()
+
+	staleFingerprint, err := s.Fingerprint()
+	assert.NoError(err)
+
+	// Someone else's edit lands on disk between us reading the
+	// fingerprint and calling DoLockedAction.
+	writeConfig(t, configPath, Config{Accounts: []Account{{ID: "a", Name: "renamed"}}})
+
+	err = s.DoLockedAction(staleFingerprint, func(cfg *Config) error {
+		cfg.Accounts[0].Name = "should not apply"
+		return nil
+	})
+	assert.Error(err)
+	assert.Regexp("changed since fingerprint", err.Error())
+}
+
+func TestDoLockedAction_AppliesAndPersistsOnMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "")
+	configPath := filepath.Join(dir, "accounts.json")
+	writeConfig(t, configPath, Config{Accounts: []Account{{ID: "a", Name: "a"}}})
+
+	s, err := NewServiceFromConfigFile(dir, configPath, "")
+	assert.NoError(err)
+	defer s.Close()
+
+	fp, err := s.Fingerprint()
+	assert.NoError(err)
+
+	err = s.DoLockedAction(fp, func(cfg *Config) error {
+		cfg.Accounts[0].Pubkey = []byte{9, 9, 9}
+		return nil
+	})
+	assert.NoError(err)
+
+	account, ok := s.lookupAccount("a")
+	assert.True(ok)
+	assert.Equal([]byte{9, 9, 9}, account.Pubkey)
+
+	var onDisk Config
+	b, err := ioutil.ReadFile(configPath)
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(b, &onDisk))
+	assert.Equal([]byte{9, 9, 9}, onDisk.Accounts[0].Pubkey)
+}
+
+func TestNewServiceFromConfigFile_ReloadsOnFileChange(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "")
+	configPath := filepath.Join(dir, "accounts.json")
+	writeConfig(t, configPath, Config{Accounts: []Account{{ID: "a", Name: "a"}}})
+
+	s, err := NewServiceFromConfigFile(dir, configPath, "")
+	assert.NoError(err)
+	defer s.Close()
+
+	writeConfig(t, configPath, Config{Accounts: []Account{{ID: "a", Name: "a"}, {ID: "b", Name: "b"}}})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := s.lookupAccount("b"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("config file change was never picked up by the watcher")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func writeConfig(t *testing.T, path string, cfg Config) {
+	t.Helper()
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,43 @@
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"roci.dev/diff-server/util/loghttp"
+)
+
+// errorBody is the JSON shape of the error responses clientError and
+// serverError write, so clients can quote requestId back to us when they
+// report a bug.
+type errorBody struct {
+	Error     string `json:"error"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// clientError writes a 4xx response with msg in a JSON errorBody and logs it at
+// Info level (it was the caller's fault, not ours).
+func clientError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	ctx := r.Context()
+	loghttp.Logger(ctx).Info().Int("status", status).Msg(msg)
+	writeError(w, status, msg, loghttp.RequestID(ctx))
+}
+
+// serverError writes a 500 response and logs err at Error level with a
+// stack trace.
+func serverError(w http.ResponseWriter, r *http.Request, err error) {
+	ctx := r.Context()
+	loghttp.Logger(ctx).Error().Err(err).Stack().Msg("Internal server error")
+	writeError(w, http.StatusInternalServerError, err.Error(), loghttp.RequestID(ctx))
+}
+
+func unsupportedMethodError(w http.ResponseWriter, r *http.Request, method string) {
+	clientError(w, r, http.StatusMethodNotAllowed, fmt.Sprintf("Unsupported method: %s", method))
+}
+
+func writeError(w http.ResponseWriter, status int, msg string, requestID string) {
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: msg, RequestID: requestID})
+}
@@ -7,56 +7,55 @@ import (
 	"net/http"
 
 	"github.com/pkg/errors"
-	zl "github.com/rs/zerolog"
 	servetypes "roci.dev/diff-server/serve/types"
 )
 
 // inject inserts a client view into the cache. This is primarily useful for testing without
 // having to have a data layer running.
-func (s *Service) inject(w http.ResponseWriter, r *http.Request, l zl.Logger) {
+func (s *Service) inject(w http.ResponseWriter, r *http.Request) {
 	if !s.enableInject {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
 	if r.Method != "POST" {
-		unsupportedMethodError(w, r.Method, l)
+		unsupportedMethodError(w, r, r.Method)
 		return
 	}
 
 	var req servetypes.InjectRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		clientError(w, http.StatusBadRequest, errors.Wrap(err, "Bad request payload").Error(), l)
+		clientError(w, r, http.StatusBadRequest, errors.Wrap(err, "Bad request payload").Error())
 		return
 	}
 
 	if req.AccountID == "" {
-		clientError(w, http.StatusBadRequest, "Missing accountID", l)
+		clientError(w, r, http.StatusBadRequest, "Missing accountID")
 		return
 	}
 
-	_, ok := lookupAccount(req.AccountID, s.accounts)
+	_, ok := s.lookupAccount(req.AccountID)
 	if !ok {
-		clientError(w, http.StatusBadRequest, "Unknown accountID", l)
+		clientError(w, r, http.StatusBadRequest, "Unknown accountID")
 		return
 	}
 
 	// TODO: auth
 
 	if req.ClientID == "" {
-		clientError(w, http.StatusBadRequest, "Missing clientID", l)
+		clientError(w, r, http.StatusBadRequest, "Missing clientID")
 		return
 	}
 
-	db, err := s.GetDB(req.AccountID, req.ClientID)
+	db, err := s.GetDB(r.Context(), req.AccountID, req.ClientID)
 	if err != nil {
-		serverError(w, err, l)
+		serverError(w, r, err)
 		return
 	}
 
-	err = storeClientView(db, req.ClientViewResponse, l)
+	err = storeClientView(db, req.ClientViewResponse)
 	if err != nil {
-		serverError(w, err, l)
+		serverError(w, r, err)
 	}
 }
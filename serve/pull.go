@@ -0,0 +1,103 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	servetypes "roci.dev/diff-server/serve/types"
+	"roci.dev/diff-server/util/loghttp"
+)
+
+// pull is the main Replicant sync endpoint. A client posts the state it
+// last synced to (baseStateID/checksum) and gets back a patch that brings
+// it up to date, fetching a fresh client view from the account's data
+// layer first if one is configured for it.
+func (s *Service) pull(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		unsupportedMethodError(w, r, r.Method)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		clientError(w, r, http.StatusBadRequest, errors.Wrap(err, "Could not read request body").Error())
+		return
+	}
+
+	var req servetypes.PullRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		clientError(w, r, http.StatusBadRequest, errors.Wrap(err, "Bad request payload").Error())
+		return
+	}
+
+	resp, err := s.handlePull(r.Context(), req, body, r.Header)
+	if err != nil {
+		clientError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		serverError(w, r, err)
+	}
+}
+
+// handlePull does the actual work of a pull: validating the request,
+// verifying its signature if the account requires one, refreshing the
+// account's client view if a fetcher is configured, and diffing against
+// the client's last-synced state. The logger in ctx is used for anything
+// that doesn't rise to the level of a client-visible error, eg a failed
+// client view fetch that falls back to cached data.
+func (s *Service) handlePull(ctx context.Context, req servetypes.PullRequest, body []byte, header http.Header) (servetypes.PullResponse, error) {
+	if req.AccountID == "" {
+		return servetypes.PullResponse{}, errors.New("Missing accountID")
+	}
+	account, ok := s.lookupAccount(req.AccountID)
+	if !ok {
+		return servetypes.PullResponse{}, errors.New("Unknown accountID")
+	}
+	if err := s.verifySignature(account, body, header); err != nil {
+		return servetypes.PullResponse{}, err
+	}
+	if req.ClientID == "" {
+		return servetypes.PullResponse{}, errors.New("Missing clientID")
+	}
+
+	authHeader := header.Get("Authorization")
+
+	var cvr *servetypes.ClientViewResponse
+	if cvg := s.clientViewGetter(req.AccountID); cvg != nil {
+		resp, err := cvg.Get(ctx, servetypes.ClientViewRequest{ClientID: req.ClientID}, authHeader)
+		if err != nil {
+			loghttp.Logger(ctx).Error().Err(err).Msg("Could not fetch client view; serving previously synced data")
+		} else {
+			cvr = &resp
+		}
+	}
+
+	return s.pullOne(ctx, req.AccountID, req.ClientID, req.BaseStateID, req.Checksum, cvr)
+}
+
+// pullOne stores a freshly fetched client view, if any, and diffs the
+// client's current state against baseStateID/checksum. It's shared by
+// pull and batchPull: both resolve whether (and how) to fetch a client
+// view themselves, since pull fetches once per request and batchPull
+// coalesces fetches across the batch.
+func (s *Service) pullOne(ctx context.Context, accountID, clientID, baseStateID, checksum string, cvr *servetypes.ClientViewResponse) (servetypes.PullResponse, error) {
+	d, err := s.GetDB(ctx, accountID, clientID)
+	if err != nil {
+		return servetypes.PullResponse{}, err
+	}
+
+	if cvr != nil {
+		if err := storeClientView(d, *cvr); err != nil {
+			return servetypes.PullResponse{}, err
+		}
+	}
+
+	return d.Pull(baseStateID, checksum)
+}
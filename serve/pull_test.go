@@ -2,6 +2,7 @@ package serve
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -137,7 +138,7 @@ func TestAPI(t *testing.T) {
 	for i, t := range tc {
 		td, _ := ioutil.TempDir("", "")
 		s := NewService(td, []Account{Account{ID: "accountID", Name: "accountID", Pubkey: nil}}, "")
-		noms, err := s.getNoms("accountID")
+		noms, err := s.getNoms(context.Background(), "accountID")
 		assert.NoError(err)
 		db, err := db.New(noms.GetDataset("client/clientid"))
 		assert.NoError(err)
@@ -189,7 +190,7 @@ type fakeClientViewGet struct {
 	gotAuth string
 }
 
-func (f *fakeClientViewGet) Get(req servetypes.ClientViewRequest, authToken string) (servetypes.ClientViewResponse, error) {
+func (f *fakeClientViewGet) Get(ctx context.Context, req servetypes.ClientViewRequest, authToken string) (servetypes.ClientViewResponse, error) {
 	f.called = true
 	f.gotReq = req
 	f.gotAuth = authToken
@@ -0,0 +1,231 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/attic-labs/noms/go/datas"
+	"github.com/attic-labs/noms/go/marshal"
+	"github.com/attic-labs/noms/go/spec"
+	"github.com/attic-labs/noms/go/types"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+
+	"roci.dev/diff-server/db"
+	"roci.dev/diff-server/kv"
+	"roci.dev/diff-server/serve/auth"
+	servetypes "roci.dev/diff-server/serve/types"
+	"roci.dev/diff-server/util/loghttp"
+)
+
+// ClientViewEndpointKind selects which transport a ClientViewGetter uses
+// to reach an account's data layer.
+type ClientViewEndpointKind string
+
+const (
+	// ClientViewEndpointHTTP fetches client views over HTTP+JSON. It's the
+	// default: the zero value of ClientViewEndpointKind ("") also selects
+	// HTTP, so existing accounts that don't set ClientViewEndpoint keep
+	// working unchanged.
+	ClientViewEndpointHTTP ClientViewEndpointKind = "http"
+	// ClientViewEndpointGRPC fetches client views by calling
+	// ClientViewService.Get over gRPC.
+	ClientViewEndpointGRPC ClientViewEndpointKind = "grpc"
+	// ClientViewEndpointNATS fetches client views over NATS request-reply.
+	ClientViewEndpointNATS ClientViewEndpointKind = "nats"
+)
+
+// ClientViewEndpoint configures how to reach an account's data layer for
+// client view fetches.
+type ClientViewEndpoint struct {
+	Kind ClientViewEndpointKind `json:"kind"`
+	// URL is the client view URL (Kind == ClientViewEndpointHTTP), the
+	// gRPC dial target (Kind == ClientViewEndpointGRPC), or the NATS
+	// server URL (Kind == ClientViewEndpointNATS). If empty for
+	// ClientViewEndpointHTTP, the Service-wide default is used instead.
+	URL string `json:"url"`
+	// Options carries transport-specific settings, eg the NATS reply
+	// timeout ("timeout", as a time.ParseDuration string).
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Account describes a Replicache customer account known to this
+// diff-server instance: how to identify it, how to reach and authenticate
+// to its data layer for client view fetches, and (optionally) the key it
+// signs pull requests with.
+type Account struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Pubkey []byte `json:"pubkey,omitempty"`
+
+	// Auth configures how client view fetches for this account
+	// authenticate to the data layer. The zero value forwards the
+	// Replicache client's own Authorization header unchanged. It applies
+	// only to the HTTP transport; gRPC and NATS fetches always forward the
+	// client's Authorization header and ignore Auth.
+	Auth auth.Config `json:"auth"`
+
+	// ClientViewEndpoint configures how client view fetches for this
+	// account reach the data layer. The zero value fetches over HTTP+JSON
+	// using the Service-wide default URL.
+	ClientViewEndpoint ClientViewEndpoint `json:"clientViewEndpoint"`
+}
+
+// Service implements the Replicant sync protocol and Noms endpoints
+// described in the serve package doc.
+type Service struct {
+	storageRoot   string
+	clientViewURL string
+	enableInject  bool
+
+	// cvg overrides clientViewGetter below for every account; set by tests.
+	cvg clientViewGet
+
+	// config holds the current Config (accounts and their auth/getter
+	// settings). It's read through an atomic.Value so pull, inject, and
+	// batchPull can look up an account without ever blocking on a lock.
+	config atomic.Value // holds Config
+
+	// configPath is the file config was loaded from, if any. It's empty
+	// for Services created with NewService, in which case the config is
+	// fixed for the life of the Service and DoLockedAction always fails.
+	configPath string
+	watcher    *fsnotify.Watcher
+	// watcherDone is closed once watchConfig's goroutine has returned, so
+	// Close can wait for it instead of merely asking the watcher to stop.
+	watcherDone chan struct{}
+	// cfgMu serializes DoLockedAction callers and reloadConfig, so a
+	// concurrent admin edit and a reload from disk can't interleave.
+	cfgMu sync.Mutex
+
+	// signatureSkew overrides defaultSignatureSkew when set; see
+	// SetSignatureSkew.
+	signatureSkew time.Duration
+	nonceOnce     sync.Once
+	nonceCache    *nonceCache
+
+	mu       sync.Mutex
+	dbs      map[string]datas.Database
+	cvgCache map[string]clientViewGet
+}
+
+// NewService creates a new Service with a fixed, in-memory set of
+// accounts. storageRoot is the directory under which each account's Noms
+// database lives; clientViewURL is the data layer endpoint pull fetches
+// client views from. enableInject is left off, matching production
+// defaults, so the /inject test endpoint responds 404; NewService has no
+// option to turn it on. Callers that want accounts to be hot-reloadable from a file
+// should use NewServiceFromConfigFile instead.
+func NewService(storageRoot string, accounts []Account, clientViewURL string) *Service {
+	s := &Service{
+		storageRoot:   storageRoot,
+		clientViewURL: clientViewURL,
+		dbs:           map[string]datas.Database{},
+		cvgCache:      map[string]clientViewGet{},
+	}
+	s.config.Store(Config{Accounts: accounts})
+	return s
+}
+
+// lookupAccount looks up accountID in the current Config snapshot. It
+// never blocks: the hot path (pull, inject, batchPull) never waits on
+// cfgMu, the writer lock DoLockedAction and reloadConfig hold.
+func (s *Service) lookupAccount(accountID string) (Account, bool) {
+	cfg := s.config.Load().(Config)
+	for _, a := range cfg.Accounts {
+		if a.ID == accountID {
+			return a, true
+		}
+	}
+	return Account{}, false
+}
+
+// getNoms returns the Noms database backing the given account, opening it
+// the first time it's requested and reusing it afterward.
+func (s *Service) getNoms(ctx context.Context, accountID string) (datas.Database, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d, ok := s.dbs[accountID]; ok {
+		return d, nil
+	}
+	loghttp.Logger(ctx).Debug().Str("accountID", accountID).Msg("Opening database for account")
+	sp, err := spec.ForDatabase(fmt.Sprintf("ldb:%s/%s", s.storageRoot, accountID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not open database for account %s", accountID)
+	}
+	d := sp.GetDatabase()
+	s.dbs[accountID] = d
+	return d, nil
+}
+
+// clientViewGetter returns the clientViewGet to use for accountID,
+// building and caching one from the account's configuration -- auth and
+// transport alike -- the first time it's needed. Tests bypass this
+// entirely by setting s.cvg directly.
+func (s *Service) clientViewGetter(accountID string) clientViewGet {
+	if s.cvg != nil {
+		return s.cvg
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g, ok := s.cvgCache[accountID]; ok {
+		return g
+	}
+
+	a, _ := s.lookupAccount(accountID)
+
+	var g clientViewGet
+	switch a.ClientViewEndpoint.Kind {
+	case ClientViewEndpointGRPC:
+		g = newGRPCClientViewGetter(a.ClientViewEndpoint.URL)
+	case ClientViewEndpointNATS:
+		g = newNATSClientViewGetter(a.ClientViewEndpoint.URL, accountID, a.ClientViewEndpoint.Options)
+	default:
+		url := a.ClientViewEndpoint.URL
+		if url == "" {
+			url = s.clientViewURL
+		}
+		// An account with no URL configured anywhere has no data layer to
+		// fetch from; leave g nil so callers skip the fetch entirely,
+		// rather than attempting (and failing) a POST to "" on every pull.
+		if url != "" {
+			var src *auth.Source
+			if a.Auth.Kind != "" {
+				src = auth.NewSource(a.Auth)
+			}
+			g = &ClientViewGetter{url: url, auth: src}
+		}
+	}
+
+	s.cvgCache[accountID] = g
+	return g
+}
+
+// GetDB returns the db.DB backing the given account and client.
+func (s *Service) GetDB(ctx context.Context, accountID, clientID string) (*db.DB, error) {
+	noms, err := s.getNoms(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return db.New(noms.GetDataset("client/" + clientID))
+}
+
+// storeClientView writes a client view fetched (or injected) out-of-band
+// into d, as though it had come back from a real pull.
+func storeClientView(d *db.DB, cvr servetypes.ClientViewResponse) error {
+	nomsValue, err := marshal.Marshal(d.Noms(), cvr.ClientView)
+	if err != nil {
+		return errors.Wrap(err, "Could not marshal client view")
+	}
+	nomsMap, ok := nomsValue.(types.Map)
+	if !ok {
+		return errors.New("Client view did not marshal to a map")
+	}
+	m := kv.NewMapFromNoms(d.Noms(), nomsMap)
+	return d.PutData(m.NomsMap(), types.String(m.Checksum().String()), cvr.LastMutationID)
+}
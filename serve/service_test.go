@@ -0,0 +1,80 @@
+package serve
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_ClientViewGetter_TransportSelection(t *testing.T) {
+	assert := assert.New(t)
+
+	td, _ := ioutil.TempDir("", "")
+
+	tests := []struct {
+		name    string
+		account Account
+		wantNil bool
+	}{
+		{"no endpoint and no service-wide URL: no fetcher", Account{ID: "a"}, true},
+		{
+			"http with per-account URL",
+			Account{ID: "a", ClientViewEndpoint: ClientViewEndpoint{URL: "http://example.com"}},
+			false,
+		},
+		{
+			"grpc",
+			Account{ID: "a", ClientViewEndpoint: ClientViewEndpoint{Kind: ClientViewEndpointGRPC, URL: "localhost:1234"}},
+			false,
+		},
+		{
+			"nats",
+			Account{ID: "a", ClientViewEndpoint: ClientViewEndpoint{Kind: ClientViewEndpointNATS, URL: "nats://localhost:4222"}},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService(td, []Account{tt.account}, "")
+			got := s.clientViewGetter(tt.account.ID)
+			if tt.wantNil {
+				assert.Nil(got)
+				return
+			}
+			assert.NotNil(got)
+
+			// A second lookup returns the cached getter rather than
+			// building a fresh one.
+			assert.Same(got, s.clientViewGetter(tt.account.ID))
+		})
+	}
+}
+
+func TestService_ClientViewGetter_FallsBackToServiceWideURL(t *testing.T) {
+	assert := assert.New(t)
+
+	td, _ := ioutil.TempDir("", "")
+	s := NewService(td, []Account{{ID: "a"}}, "http://default.example.com")
+	got := s.clientViewGetter("a")
+	assert.NotNil(got)
+}
+
+func TestNewNATSClientViewGetter_SubjectAndTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newNATSClientViewGetter("nats://localhost:4222", "accountID", nil)
+	assert.Equal("clientview.accountID", g.subject)
+	assert.Equal(defaultNATSTimeout, g.timeout)
+
+	g = newNATSClientViewGetter("nats://localhost:4222", "accountID", map[string]string{"timeout": "30s"})
+	assert.Equal(30*time.Second, g.timeout)
+
+	// An unparseable timeout option falls back to the default rather than
+	// erroring; there's no good synchronous way to surface a config
+	// mistake this deep in a lazily-built getter.
+	g = newNATSClientViewGetter("nats://localhost:4222", "accountID", map[string]string{"timeout": "not-a-duration"})
+	assert.Equal(defaultNATSTimeout, g.timeout)
+}
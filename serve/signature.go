@@ -0,0 +1,136 @@
+package serve
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"roci.dev/diff-server/serve/signing"
+)
+
+// defaultSignatureSkew is how far a signed request's X-Diff-Timestamp may
+// drift from the server's clock, in either direction, before it's
+// rejected. It's generous enough to tolerate a slow mobile network and a
+// modestly wrong client clock.
+const defaultSignatureSkew = 5 * time.Minute
+
+// maxNonces bounds how many (accountID, nonce) pairs the replay cache
+// remembers. It only needs to cover nonces seen within the skew window,
+// since anything older is already rejected on timestamp grounds; this is
+// a generous multiple of what any reasonable request volume needs for
+// that window.
+const maxNonces = 100000
+
+// verifySignature enforces account's signing requirement, if any, against
+// a request's body and header: if account.Pubkey is unset, every request
+// is accepted unchanged, so accounts that haven't opted into signing keep
+// working exactly as before. Otherwise header must carry a valid, fresh,
+// not-previously-seen signature over body.
+func (s *Service) verifySignature(account Account, body []byte, header http.Header) error {
+	if len(account.Pubkey) == 0 {
+		return nil
+	}
+
+	ts := header.Get(signing.TimestampHeader)
+	nonce := header.Get(signing.NonceHeader)
+	sig := header.Get(signing.SignatureHeader)
+	if ts == "" || nonce == "" || sig == "" {
+		return errors.New("Missing request signature")
+	}
+
+	skew := s.signatureSkew
+	if skew == 0 {
+		skew = defaultSignatureSkew
+	}
+	if !withinSkew(ts, skew) {
+		return errors.New("Request timestamp is outside the accepted window")
+	}
+
+	if !signing.Verify(account.Pubkey, body, ts, nonce, sig) {
+		return errors.New("Invalid request signature")
+	}
+
+	if !s.nonces().claim(account.ID, nonce) {
+		return errors.New("Request nonce has already been used")
+	}
+
+	return nil
+}
+
+// SetSignatureSkew overrides the default window a signed request's
+// timestamp is allowed to drift from the server's clock. It's meant to be
+// called once, at startup.
+func (s *Service) SetSignatureSkew(d time.Duration) {
+	s.signatureSkew = d
+}
+
+// nonces returns the Service's replay cache, creating it the first time
+// it's needed.
+func (s *Service) nonces() *nonceCache {
+	s.nonceOnce.Do(func() {
+		s.nonceCache = newNonceCache(maxNonces)
+	})
+	return s.nonceCache
+}
+
+// nonceCache remembers recently-seen (accountID, nonce) pairs so a
+// captured, validly-signed request can't be replayed. It's bounded, in
+// order of first claim, rather than a set that only grows, so a long-running server doesn't
+// accumulate memory forever: once it's full, the oldest entry is evicted
+// to make room, which is safe because anything that old has already
+// fallen outside any reasonable signature skew window.
+type nonceCache struct {
+	max int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front = most recently claimed
+}
+
+func newNonceCache(max int) *nonceCache {
+	return &nonceCache{
+		max:     max,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// claim records accountID/nonce as seen and reports true, unless it was
+// already seen, in which case it reports false without modifying the
+// cache.
+func (c *nonceCache) claim(accountID, nonce string) bool {
+	key := accountID + "|" + nonce
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return false
+	}
+
+	c.entries[key] = c.order.PushFront(key)
+	for len(c.entries) > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(string))
+	}
+	return true
+}
+
+// withinSkew reports whether ts (a signing.FormatTimestamp-encoded Unix
+// time) is within skew of the current time.
+func withinSkew(ts string, skew time.Duration) bool {
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	diff := time.Since(time.Unix(secs, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= skew
+}
@@ -0,0 +1,137 @@
+package serve
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"roci.dev/diff-server/serve/signing"
+)
+
+func TestVerifySignature_UnsignedAccountAlwaysPasses(t *testing.T) {
+	assert := assert.New(t)
+
+	td, _ := ioutil.TempDir("", "")
+	s := NewService(td, nil, "")
+
+	err := s.verifySignature(Account{ID: "a"}, []byte("body"), http.Header{})
+	assert.NoError(err)
+}
+
+func TestVerifySignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	account := Account{ID: "a", Pubkey: pub}
+	body := []byte(`{"accountID":"a","clientID":"c"}`)
+
+	sign := func(ts, nonce string) http.Header {
+		h := http.Header{}
+		h.Set(signing.TimestampHeader, ts)
+		h.Set(signing.NonceHeader, nonce)
+		h.Set(signing.SignatureHeader, signing.Sign(priv, body, mustParseTS(ts), nonce))
+		return h
+	}
+
+	now := signing.FormatTimestamp(time.Now())
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		wantErr string
+	}{
+		{"valid", sign(now, "n1"), ""},
+		{"missing headers", http.Header{}, "Missing request signature"},
+		{"timestamp too old", sign(signing.FormatTimestamp(time.Now().Add(-time.Hour)), "n2"), "outside the accepted window"},
+		{"timestamp too far in the future", sign(signing.FormatTimestamp(time.Now().Add(time.Hour)), "n3"), "outside the accepted window"},
+		{"wrong signature", func() http.Header {
+			h := sign(now, "n4")
+			h.Set(signing.SignatureHeader, "AAAA")
+			return h
+		}(), "Invalid request signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td, _ := ioutil.TempDir("", "")
+			s := NewService(td, nil, "")
+
+			err := s.verifySignature(account, body, tt.header)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				assert.Regexp(t, tt.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestVerifySignature_RejectsReplayedNonce(t *testing.T) {
+	assert := assert.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	account := Account{ID: "a", Pubkey: pub}
+	body := []byte(`{"accountID":"a"}`)
+
+	td, _ := ioutil.TempDir("", "")
+	s := NewService(td, nil, "")
+
+	now := time.Now()
+	h := http.Header{}
+	h.Set(signing.TimestampHeader, signing.FormatTimestamp(now))
+	h.Set(signing.NonceHeader, "reused-nonce")
+	h.Set(signing.SignatureHeader, signing.Sign(priv, body, now, "reused-nonce"))
+
+	assert.NoError(s.verifySignature(account, body, h))
+	err = s.verifySignature(account, body, h)
+	assert.Error(err)
+	assert.Regexp("already been used", err.Error())
+}
+
+func TestVerifySignature_SetSignatureSkew(t *testing.T) {
+	assert := assert.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	account := Account{ID: "a", Pubkey: pub}
+	body := []byte(`{"accountID":"a"}`)
+
+	td, _ := ioutil.TempDir("", "")
+	s := NewService(td, nil, "")
+	s.SetSignatureSkew(time.Millisecond)
+
+	past := time.Now().Add(-time.Second)
+	h := http.Header{}
+	h.Set(signing.TimestampHeader, signing.FormatTimestamp(past))
+	h.Set(signing.NonceHeader, "n")
+	h.Set(signing.SignatureHeader, signing.Sign(priv, body, past, "n"))
+
+	err = s.verifySignature(account, body, h)
+	assert.Error(err)
+	assert.Regexp("outside the accepted window", err.Error())
+}
+
+func TestNonceCache_EvictsOldestOnceFull(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newNonceCache(2)
+	assert.True(c.claim("a", "n1"))
+	assert.True(c.claim("a", "n2"))
+	// n1 is now the oldest; claiming a third nonce evicts it.
+	assert.True(c.claim("a", "n3"))
+	assert.True(c.claim("a", "n1"), "n1 should have been evicted and be claimable again")
+}
+
+func mustParseTS(ts string) time.Time {
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	return time.Unix(secs, 0)
+}
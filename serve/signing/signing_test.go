@@ -0,0 +1,53 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+
+	body := []byte(`{"accountID":"a","clientID":"c"}`)
+	now := time.Now()
+	sig := Sign(priv, body, now, "nonce1")
+
+	assert.True(Verify(pub, body, FormatTimestamp(now), "nonce1", sig))
+	assert.False(Verify(pub, []byte(`{"tampered":true}`), FormatTimestamp(now), "nonce1", sig), "a modified body must not verify")
+	assert.False(Verify(pub, body, FormatTimestamp(now), "nonce2", sig), "a modified nonce must not verify")
+	assert.False(Verify(pub, body, FormatTimestamp(now.Add(time.Hour)), "nonce1", sig), "a modified timestamp must not verify")
+
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	assert.False(Verify(otherPub, body, FormatTimestamp(now), "nonce1", sig), "a different key must not verify")
+}
+
+func TestVerify_MalformedSignature(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	assert.False(t, Verify(pub, []byte("body"), "123", "nonce", "not-base64!!"))
+}
+
+func TestSignRequest_SetsHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+
+	body := []byte(`{"accountID":"a"}`)
+	r := httptest.NewRequest("POST", "/sync", nil)
+	SignRequest(r, priv, body, "the-nonce")
+
+	assert.Equal("the-nonce", r.Header.Get(NonceHeader))
+	assert.NotEmpty(r.Header.Get(TimestampHeader))
+	assert.NotEmpty(r.Header.Get(SignatureHeader))
+
+	assert.True(Verify(pub, body, r.Header.Get(TimestampHeader), r.Header.Get(NonceHeader), r.Header.Get(SignatureHeader)))
+}
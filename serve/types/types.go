@@ -13,3 +13,72 @@ type HandleSyncResponse struct {
 	Patch        []jsonpatch.Operation `json:"patch"`
 	NomsChecksum string                `json:"nomsChecksum"`
 }
+
+// PullRequest is the body of a POST to /pull. It identifies the account and
+// client doing the pull, and the state the client last synced to.
+type PullRequest struct {
+	AccountID   string `json:"accountID"`
+	ClientID    string `json:"clientID"`
+	BaseStateID string `json:"baseStateID"`
+	Checksum    string `json:"checksum"`
+}
+
+// PullResponse is returned from a successful pull. Patch, applied atop
+// BaseStateID, brings the client from its old state to StateID.
+type PullResponse struct {
+	StateID        string                `json:"stateID"`
+	LastMutationID uint64                `json:"lastMutationID"`
+	Patch          []jsonpatch.Operation `json:"patch"`
+	Checksum       string                `json:"checksum"`
+}
+
+// ClientViewRequest is sent to a customer's data layer to fetch the client
+// view for a single Replicache client.
+type ClientViewRequest struct {
+	ClientID string `json:"clientID"`
+}
+
+// ClientViewResponse is a data layer's response to a ClientViewRequest.
+type ClientViewResponse struct {
+	ClientView     map[string]interface{} `json:"clientView"`
+	LastMutationID uint64                  `json:"lastMutationID"`
+}
+
+// InjectRequest is the body of a POST to /inject. It is used by tests to
+// seed a client view into the cache without having to run a data layer.
+type InjectRequest struct {
+	AccountID          string             `json:"accountID"`
+	ClientID           string             `json:"clientID"`
+	ClientViewResponse ClientViewResponse `json:"clientViewResponse"`
+}
+
+// BatchPullRequest is the body of a POST to /batchPull: many clients'
+// worth of PullRequest, all under a single account, modeled on the LFS
+// batch API's {operation, objects[]} shape.
+type BatchPullRequest struct {
+	Operation string            `json:"operation"`
+	AccountID string            `json:"accountID"`
+	Pulls     []BatchPullObject `json:"objects"`
+}
+
+// BatchPullObject is one client's pull request within a BatchPullRequest.
+type BatchPullObject struct {
+	ClientID    string `json:"clientID"`
+	BaseStateID string `json:"baseStateID"`
+	Checksum    string `json:"checksum"`
+}
+
+// BatchPullResponse is the result of a /batchPull call: one result per
+// requested object, in the same order, so a failure for one client
+// doesn't fail the whole batch.
+type BatchPullResponse struct {
+	Objects []BatchPullResult `json:"objects"`
+}
+
+// BatchPullResult is a single client's outcome within a BatchPullResponse.
+type BatchPullResult struct {
+	ClientID string        `json:"clientID"`
+	Status   int           `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Pull     *PullResponse `json:"pull,omitempty"`
+}
@@ -3,12 +3,14 @@ package loghttp
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 
 	lh "github.com/motemen/go-loghttp"
+	"github.com/rs/xid"
 	zl "github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
 
@@ -16,6 +18,38 @@ import (
 	_ "github.com/motemen/go-loghttp/global"
 )
 
+// RequestIDHeader is the header diff-server reads an inbound request ID
+// from, and always stamps (generated or forwarded) onto the response.
+const RequestIDHeader = "X-Request-Id"
+
+type ctxKey int
+
+const (
+	loggerCtxKey ctxKey = iota
+	requestIDCtxKey
+)
+
+// WithLogger returns a copy of ctx carrying l, retrievable with Logger.
+func WithLogger(ctx context.Context, l zl.Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey, l)
+}
+
+// Logger returns the logger stashed in ctx by WithLogger, or a disabled
+// logger if none was stashed (eg outside of a request).
+func Logger(ctx context.Context) zl.Logger {
+	if l, ok := ctx.Value(loggerCtxKey).(zl.Logger); ok {
+		return l
+	}
+	return zl.Nop()
+}
+
+// RequestID returns the request ID stashed in ctx by Handler, or "" if
+// none was stashed.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtxKey).(string)
+	return id
+}
+
 func init() {
 	lh.DefaultLogRequest = func(req *http.Request) {
 		dumped, err := httputil.DumpRequest(req, true)
@@ -55,29 +89,58 @@ func Wrap(handler http.Handler, l zl.Logger) Handler {
 }
 
 // Handler is a wrapper for http.Handlers that logs the HTTP request and
-// response. It logs full request headers but logging full response headers
-// seems like more work (eg
+// response, and tags the request with a stable request ID. It logs full
+// request headers but logging full response headers seems like more work
+// (eg
 // https://stackoverflow.com/questions/29319783/logging-responses-to-incoming-http-requests-inside-http-handlefunc)
 // so we settle for logging the response status code and response body for now.
 type Handler struct {
 	wrapped http.Handler
 	l       zl.Logger
+
+	// legacyRequestIDHeader, if set, is honored as a fallback source for
+	// the inbound request ID when RequestIDHeader isn't present. This
+	// exists so clients using an older correlation-id header keep working
+	// while they migrate.
+	legacyRequestIDHeader string
+}
+
+// WithLegacyRequestIDHeader returns a copy of h that also honors name as
+// a fallback inbound request ID header, for clients that haven't
+// migrated to RequestIDHeader yet.
+func (h Handler) WithLegacyRequestIDHeader(name string) Handler {
+	h.legacyRequestIDHeader = name
+	return h
 }
 
-// ServeHTTP logs the request, calls the underlying handler, and logs the response.
+// ServeHTTP tags the request with a request ID, logs the request, calls
+// the underlying handler, and logs the response.
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	dumped, err := httputil.DumpRequest(r, true)
-	if err != nil {
-		h.l.Err(err).Stack().Msg("Could not dump request")
-		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
-		return
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" && h.legacyRequestIDHeader != "" {
+		id = r.Header.Get(h.legacyRequestIDHeader)
+	}
+	if id == "" {
+		id = xid.New().String()
 	}
+	w.Header().Set(RequestIDHeader, id)
 
 	ll := h.l.With().
 		Str("method", r.Method).
 		Str("req", r.URL.String()).
+		Str("reqID", id).
 		Logger()
 
+	ctx := WithLogger(context.WithValue(r.Context(), requestIDCtxKey, id), ll)
+	r = r.WithContext(ctx)
+
+	dumped, err := httputil.DumpRequest(r, true)
+	if err != nil {
+		ll.Err(err).Stack().Msg("Could not dump request")
+		http.Error(w, fmt.Sprint(err), http.StatusInternalServerError)
+		return
+	}
+
 	ll.Debug().
 		Bytes("dump", dumped).
 		Msg("Incoming request -->")
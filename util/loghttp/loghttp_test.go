@@ -0,0 +1,73 @@
+package loghttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	zl "github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_ServeHTTP_RequestID(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		name            string
+		legacyHeader    string
+		reqHeaders      map[string]string
+		wantIDFromReq   bool
+		wantLegacyIDUse bool
+	}{
+		{"generates an id when none is supplied", "", nil, false, false},
+		{"forwards the inbound X-Request-Id unchanged", "", map[string]string{RequestIDHeader: "abc123"}, true, false},
+		{"falls back to the legacy header when X-Request-Id is absent", "X-Correlation-Id", map[string]string{"X-Correlation-Id": "legacy-id"}, false, true},
+		{"prefers X-Request-Id over the legacy header", "X-Correlation-Id", map[string]string{RequestIDHeader: "abc123", "X-Correlation-Id": "legacy-id"}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotID string
+			wrapped := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotID = RequestID(r.Context())
+			})
+
+			h := Wrap(wrapped, zl.Nop())
+			if tt.legacyHeader != "" {
+				h = h.WithLegacyRequestIDHeader(tt.legacyHeader)
+			}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tt.reqHeaders {
+				req.Header.Set(k, v)
+			}
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+
+			assert.NotEmpty(gotID)
+			assert.Equal(gotID, resp.Result().Header.Get(RequestIDHeader))
+
+			switch {
+			case tt.wantIDFromReq:
+				assert.Equal("abc123", gotID)
+			case tt.wantLegacyIDUse:
+				assert.Equal("legacy-id", gotID)
+			}
+		})
+	}
+}
+
+func TestWithLogger_Logger(t *testing.T) {
+	assert := assert.New(t)
+
+	// No logger stashed: Logger returns a disabled one rather than panicking.
+	assert.Equal(zl.Nop(), Logger(httptest.NewRequest("GET", "/", nil).Context()))
+
+	l := zl.New(nil)
+	ctx := WithLogger(httptest.NewRequest("GET", "/", nil).Context(), l)
+	assert.Equal(l, Logger(ctx))
+}
+
+func TestRequestID_NoneStashed(t *testing.T) {
+	assert.Equal(t, "", RequestID(httptest.NewRequest("GET", "/", nil).Context()))
+}